@@ -52,6 +52,10 @@ type Config struct {
 	// "setuid": impersonate into user nobody (65534), default
 	// "namespace": create a new namespace for fuzzer using CLONE_NEWNS/CLONE_NEWNET/CLONE_NEWPID/etc,
 	//	requires building kernel with CONFIG_NAMESPACES, CONFIG_UTS_NS, CONFIG_USER_NS, CONFIG_PID_NS and CONFIG_NET_NS.
+	// "capabilities": stay root but capset(2) down to an explicit bounding set, see Sandbox_Caps_Keep/Drop.
+
+	Sandbox_Caps_Keep []string // capabilities to keep in the bounding set (e.g. "CAP_SYS_MODULE"), only for sandbox "capabilities"
+	Sandbox_Caps_Drop []string // capabilities to drop from the bounding set, only for sandbox "capabilities"
 
 	Machine_Type string // GCE machine type (e.g. "n1-highcpu-2")
 
@@ -62,6 +66,32 @@ type Config struct {
 	Odroid_Hub_Device int    // host USB device number for the USB hub
 	Odroid_Hub_Port   int    // port on the USB hub to which Odroid is connected
 
+	// Host_Devices lists host devices to pass through into the guest, e.g. "/dev/kvm",
+	// "/dev/vhost-net", "/dev/dri/renderD128", or "vendor:product" for USB devices.
+	// Entries may contain the %INDEX% token, which is replaced with the VM index,
+	// so that per-instance device selection is expressible in one line.
+	Host_Devices []string
+
+	Cgroup_Parent    string // parent cgroup under /sys/fs/cgroup to nest per-instance cgroups in (type "local"/"none" only)
+	Cgroup_Mem_Max   string // memory.max value, e.g. "2G" (optional)
+	Cgroup_Cpu_Max   string // cpu.max value, e.g. "200000 100000" (optional)
+	Cgroup_Pids_Max  int    // pids.max value (optional)
+	Cgroup_IO_Weight int    // io.weight value, 1-10000 (optional)
+
+	Avf_Device       string   // host adb serial that hosts the virtualizationservice
+	Avf_Protected    bool     // boot the guest as a protected (pKVM) VM
+	Avf_Cpu_Topology string   // "one_cpu" or "match_host"
+	Avf_Memory_Mib   int      // guest memory size in MiB
+	Avf_Payload_Apk  string   // APK on the host containing the microdroid payload
+	Avf_Disks        []string // extra composite disk images to attach to the guest
+
+	Crosvm_Bin            string   // path to the crosvm binary (required for type "crosvm")
+	Crosvm_Wayland_Socket string   // path to a wayland socket to forward into the guest (optional)
+	Crosvm_RwDisks        []string // extra disk images to attach read-write, besides the main image
+	Crosvm_Params         []string // additional crosvm command line arguments
+	Crosvm_Tap            string   // tap device name prefix for the guest nic (instance index is appended)
+	Crosvm_Bridge         string   // bridge to attach the tap device to
+
 	Cover     bool // use kcov coverage (default: true)
 	Leak      bool // do memory leak checking
 	Reproduce bool // reproduce, localize and minimize crashers (on by default)
@@ -74,6 +104,8 @@ type Config struct {
 	// Implementation details beyond this point.
 	ParsedSuppressions []*regexp.Regexp `json:"-"`
 	ParsedIgnores      []*regexp.Regexp `json:"-"`
+	CapsKeepMask       uint64           `json:"-"`
+	CapsDropMask       uint64           `json:"-"`
 }
 
 func Parse(filename string) (*Config, map[int]bool, error) {
@@ -122,6 +154,25 @@ func Parse(filename string) (*Config, map[int]bool, error) {
 			return nil, nil, fmt.Errorf("specify at least 1 adb device")
 		}
 		cfg.Count = len(cfg.Devices)
+	case "avf":
+		if cfg.Count != 0 {
+			return nil, nil, fmt.Errorf("don't specify count for avf, it always runs a single microdroid guest")
+		}
+		if cfg.Avf_Device == "" {
+			return nil, nil, fmt.Errorf("config param avf_device is empty")
+		}
+		if cfg.Avf_Payload_Apk == "" {
+			return nil, nil, fmt.Errorf("config param avf_payload_apk is empty")
+		}
+		switch cfg.Avf_Cpu_Topology {
+		case "", "one_cpu", "match_host":
+		default:
+			return nil, nil, fmt.Errorf("config param avf_cpu_topology must contain one of one_cpu/match_host")
+		}
+		if cfg.Avf_Memory_Mib < 0 {
+			return nil, nil, fmt.Errorf("config param avf_memory_mib must not be negative")
+		}
+		cfg.Count = 1
 	case "odroid":
 		if cfg.Count != 1 {
 			return nil, nil, fmt.Errorf("no support for multiple Odroid devices yet, count should be 1")
@@ -149,6 +200,20 @@ func Parse(filename string) (*Config, map[int]bool, error) {
 			return nil, nil, fmt.Errorf("machine_type parameter is empty (required for gce)")
 		}
 		fallthrough
+	case "crosvm":
+		if cfg.Type == "crosvm" {
+			if cfg.Crosvm_Bin == "" {
+				return nil, nil, fmt.Errorf("config param crosvm_bin is empty (required for type \"crosvm\")")
+			}
+			if !filepath.IsAbs(cfg.Crosvm_Bin) {
+				return nil, nil, fmt.Errorf("config param crosvm_bin must be an absolute path")
+			}
+			if cfg.Count > 1 && cfg.Crosvm_Tap == "" && cfg.Crosvm_Bridge == "" {
+				return nil, nil, fmt.Errorf("config param count > 1 requires crosvm_tap or crosvm_bridge" +
+					" (crosvm does not manage its own userspace network)")
+			}
+		}
+		fallthrough
 	default:
 		if cfg.Count <= 0 || cfg.Count > 1000 {
 			return nil, nil, fmt.Errorf("invalid config param count: %v, want (1, 1000]", cfg.Count)
@@ -179,9 +244,46 @@ func Parse(filename string) (*Config, map[int]bool, error) {
 		return nil, nil, fmt.Errorf("config param output must contain one of none/stdout/dmesg/file")
 	}
 	switch cfg.Sandbox {
-	case "none", "setuid", "namespace":
+	case "none", "setuid", "namespace", "capabilities":
 	default:
-		return nil, nil, fmt.Errorf("config param sandbox must contain one of none/setuid/namespace")
+		return nil, nil, fmt.Errorf("config param sandbox must contain one of none/setuid/namespace/capabilities")
+	}
+	if cfg.Sandbox != "capabilities" {
+		if len(cfg.Sandbox_Caps_Keep) != 0 || len(cfg.Sandbox_Caps_Drop) != 0 {
+			return nil, nil, fmt.Errorf("sandbox_caps_keep/sandbox_caps_drop are only accepted for sandbox \"capabilities\"")
+		}
+	} else {
+		keep, err := parseCapabilities(cfg.Sandbox_Caps_Keep)
+		if err != nil {
+			return nil, nil, fmt.Errorf("bad sandbox_caps_keep: %v", err)
+		}
+		drop, err := parseCapabilities(cfg.Sandbox_Caps_Drop)
+		if err != nil {
+			return nil, nil, fmt.Errorf("bad sandbox_caps_drop: %v", err)
+		}
+		if keep&drop != 0 {
+			return nil, nil, fmt.Errorf("sandbox_caps_keep and sandbox_caps_drop both list the same capability")
+		}
+		cfg.CapsKeepMask = keep
+		cfg.CapsDropMask = drop
+	}
+	if cfg.Cgroup_Parent != "" || cfg.Cgroup_Mem_Max != "" || cfg.Cgroup_Cpu_Max != "" ||
+		cfg.Cgroup_Pids_Max != 0 || cfg.Cgroup_IO_Weight != 0 {
+		if cfg.Type != "local" && cfg.Type != "none" {
+			return nil, nil, fmt.Errorf("cgroup_* params are only supported for type \"local\"/\"none\"")
+		}
+		if cfg.Cgroup_Parent == "" {
+			return nil, nil, fmt.Errorf("cgroup_parent must be set to use other cgroup_* params")
+		}
+		if cfg.Cgroup_IO_Weight != 0 && (cfg.Cgroup_IO_Weight < 1 || cfg.Cgroup_IO_Weight > 10000) {
+			return nil, nil, fmt.Errorf("config param cgroup_io_weight must be in [1, 10000]")
+		}
+		if cfg.Cgroup_Pids_Max < 0 {
+			return nil, nil, fmt.Errorf("config param cgroup_pids_max must not be negative")
+		}
+	}
+	if err := parseHostDevices(cfg); err != nil {
+		return nil, nil, err
 	}
 
 	wd, err := os.Getwd()
@@ -279,6 +381,88 @@ func parseSyscalls(cfg *Config) (map[int]bool, error) {
 	return syscalls, nil
 }
 
+// capabilityBits maps capability names to their numeric value as defined by
+// the kernel in include/uapi/linux/capability.h.
+var capabilityBits = map[string]uint{
+	"CAP_CHOWN":            0,
+	"CAP_DAC_OVERRIDE":     1,
+	"CAP_DAC_READ_SEARCH":  2,
+	"CAP_FOWNER":           3,
+	"CAP_FSETID":           4,
+	"CAP_KILL":             5,
+	"CAP_SETGID":           6,
+	"CAP_SETUID":           7,
+	"CAP_SETPCAP":          8,
+	"CAP_LINUX_IMMUTABLE":  9,
+	"CAP_NET_BIND_SERVICE": 10,
+	"CAP_NET_BROADCAST":    11,
+	"CAP_NET_ADMIN":        12,
+	"CAP_NET_RAW":          13,
+	"CAP_IPC_LOCK":         14,
+	"CAP_IPC_OWNER":        15,
+	"CAP_SYS_MODULE":       16,
+	"CAP_SYS_RAWIO":        17,
+	"CAP_SYS_CHROOT":       18,
+	"CAP_SYS_PTRACE":       19,
+	"CAP_SYS_PACCT":        20,
+	"CAP_SYS_ADMIN":        21,
+	"CAP_SYS_BOOT":         22,
+	"CAP_SYS_NICE":         23,
+	"CAP_SYS_RESOURCE":     24,
+	"CAP_SYS_TIME":         25,
+	"CAP_SYS_TTY_CONFIG":   26,
+	"CAP_MKNOD":            27,
+	"CAP_LEASE":            28,
+	"CAP_AUDIT_WRITE":      29,
+	"CAP_AUDIT_CONTROL":    30,
+	"CAP_SETFCAP":          31,
+	"CAP_MAC_OVERRIDE":     32,
+	"CAP_MAC_ADMIN":        33,
+	"CAP_SYSLOG":           34,
+	"CAP_WAKE_ALARM":       35,
+	"CAP_BLOCK_SUSPEND":    36,
+	"CAP_AUDIT_READ":       37,
+}
+
+// parseCapabilities resolves a list of capability names (e.g. "CAP_SYS_PTRACE")
+// into a bitmask suitable for capset(2), rejecting unknown names.
+func parseCapabilities(names []string) (uint64, error) {
+	var mask uint64
+	for _, name := range names {
+		bit, ok := capabilityBits[name]
+		if !ok {
+			return 0, fmt.Errorf("unknown capability: %v", name)
+		}
+		mask |= uint64(1) << bit
+	}
+	return mask, nil
+}
+
+var usbDeviceRe = regexp.MustCompile(`^[0-9a-fA-F]{4}:[0-9a-fA-F]{4}$`)
+
+// parseHostDevices validates Host_Devices: PCI/char devices must exist on disk
+// (the %INDEX% token is checked against index 0), USB devices are given as
+// "vendor:product" and not checked against the filesystem, and no entries are
+// allowed for type "gce" since passthrough is meaningless there.
+func parseHostDevices(cfg *Config) error {
+	if len(cfg.Host_Devices) == 0 {
+		return nil
+	}
+	if cfg.Type == "gce" {
+		return fmt.Errorf("host_devices is not supported for type \"gce\"")
+	}
+	for _, dev := range cfg.Host_Devices {
+		if usbDeviceRe.MatchString(dev) {
+			continue
+		}
+		path := strings.Replace(dev, "%INDEX%", "0", -1)
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("host_devices entry %q does not exist: %v", dev, err)
+		}
+	}
+	return nil
+}
+
 func parseSuppressions(cfg *Config) error {
 	// Add some builtin suppressions.
 	supp := append(cfg.Suppressions, []string{
@@ -319,30 +503,56 @@ func CreateVMConfig(cfg *Config, index int) (*vm.Config, error) {
 		return nil, fmt.Errorf("failed to create instance temp dir: %v", err)
 	}
 	vmCfg := &vm.Config{
-		Name:            fmt.Sprintf("%v-%v-%v", cfg.Type, cfg.Name, index),
-		Index:           index,
-		Workdir:         workdir,
-		Bin:             cfg.Bin,
-		BinArgs:         cfg.Bin_Args,
-		Kernel:          cfg.Kernel,
-		Cmdline:         cfg.Cmdline,
-		Image:           cfg.Image,
-		Initrd:          cfg.Initrd,
-		Sshkey:          cfg.Sshkey,
-		Executor:        filepath.Join(cfg.Syzkaller, "bin", "syz-executor"),
-		Cpu:             cfg.Cpu,
-		Mem:             cfg.Mem,
-		Debug:           cfg.Debug,
-		MachineType:     cfg.Machine_Type,
-		OdroidHostAddr:  cfg.Odroid_Host_Addr,
-		OdroidSlaveAddr: cfg.Odroid_Slave_Addr,
-		OdroidConsole:   cfg.Odroid_Console,
-		OdroidHubBus:    cfg.Odroid_Hub_Bus,
-		OdroidHubDevice: cfg.Odroid_Hub_Device,
-		OdroidHubPort:   cfg.Odroid_Hub_Port,
+		Name:                fmt.Sprintf("%v-%v-%v", cfg.Type, cfg.Name, index),
+		Index:               index,
+		Workdir:             workdir,
+		Bin:                 cfg.Bin,
+		BinArgs:             cfg.Bin_Args,
+		Kernel:              cfg.Kernel,
+		Cmdline:             cfg.Cmdline,
+		Image:               cfg.Image,
+		Initrd:              cfg.Initrd,
+		Sshkey:              cfg.Sshkey,
+		Executor:            filepath.Join(cfg.Syzkaller, "bin", "syz-executor"),
+		Cpu:                 cfg.Cpu,
+		Mem:                 cfg.Mem,
+		Debug:               cfg.Debug,
+		MachineType:         cfg.Machine_Type,
+		OdroidHostAddr:      cfg.Odroid_Host_Addr,
+		OdroidSlaveAddr:     cfg.Odroid_Slave_Addr,
+		OdroidConsole:       cfg.Odroid_Console,
+		OdroidHubBus:        cfg.Odroid_Hub_Bus,
+		OdroidHubDevice:     cfg.Odroid_Hub_Device,
+		OdroidHubPort:       cfg.Odroid_Hub_Port,
+		CrosvmBin:           cfg.Crosvm_Bin,
+		CrosvmWaylandSocket: cfg.Crosvm_Wayland_Socket,
+		CrosvmRwDisks:       cfg.Crosvm_RwDisks,
+		CrosvmParams:        cfg.Crosvm_Params,
+		SandboxCapsKeep:     cfg.CapsKeepMask,
+		SandboxCapsDrop:     cfg.CapsDropMask,
+		AvfDevice:           cfg.Avf_Device,
+		AvfProtected:        cfg.Avf_Protected,
+		AvfCpuTopology:      cfg.Avf_Cpu_Topology,
+		AvfMemoryMib:        cfg.Avf_Memory_Mib,
+		AvfPayloadApk:       cfg.Avf_Payload_Apk,
+		AvfDisks:            cfg.Avf_Disks,
+		CgroupParent:        cfg.Cgroup_Parent,
+		CgroupMemMax:        cfg.Cgroup_Mem_Max,
+		CgroupCpuMax:        cfg.Cgroup_Cpu_Max,
+		CgroupPidsMax:       cfg.Cgroup_Pids_Max,
+		CgroupIOWeight:      cfg.Cgroup_IO_Weight,
 	}
 	if len(cfg.Devices) != 0 {
 		vmCfg.Device = cfg.Devices[index]
 	}
+	if cfg.Crosvm_Tap != "" {
+		vmCfg.CrosvmTap = fmt.Sprintf("%v%v", cfg.Crosvm_Tap, index)
+	}
+	if cfg.Crosvm_Bridge != "" {
+		vmCfg.CrosvmBridge = cfg.Crosvm_Bridge
+	}
+	for _, dev := range cfg.Host_Devices {
+		vmCfg.HostDevices = append(vmCfg.HostDevices, strings.Replace(dev, "%INDEX%", fmt.Sprintf("%v", index), -1))
+	}
 	return vmCfg, nil
 }
@@ -0,0 +1,132 @@
+// Copyright 2015 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+// Package avf implements the vm.Pool/vm.Instance interfaces for the Android
+// Virtualization Framework, routing through the host's virtualizationservice
+// (AIDL) via adb rather than a raw adb-shell into the host Android system.
+package avf
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/syzkaller/vm"
+)
+
+func init() {
+	vm.Register("avf", ctor)
+}
+
+const microdroidBin = "/apex/com.android.virt/bin/vm"
+
+type pool struct {
+	cfg *vm.Config
+}
+
+func ctor(cfg *vm.Config) (vm.Pool, error) {
+	return &pool{cfg: cfg}, nil
+}
+
+func (p *pool) Count() int {
+	return 1
+}
+
+type instance struct {
+	cfg *vm.Config
+}
+
+func (p *pool) Create(index int) (vm.Instance, error) {
+	inst := &instance{cfg: p.cfg}
+	args := []string{"-s", p.cfg.AvfDevice, "shell", microdroidBin, "run-microdroid",
+		"--payload-path", p.cfg.AvfPayloadApk}
+	if p.cfg.AvfProtected {
+		args = append(args, "--protected")
+	}
+	switch p.cfg.AvfCpuTopology {
+	case "one_cpu":
+		args = append(args, "--cpu-topology", "one_cpu")
+	case "match_host":
+		args = append(args, "--cpu-topology", "match_host")
+	}
+	if p.cfg.AvfMemoryMib != 0 {
+		args = append(args, "--memory-mib", fmt.Sprintf("%v", p.cfg.AvfMemoryMib))
+	}
+	for _, disk := range p.cfg.AvfDisks {
+		args = append(args, "--extra-disk", disk)
+	}
+	if err := exec.Command("adb", args...).Start(); err != nil {
+		return nil, fmt.Errorf("failed to start microdroid guest: %v", err)
+	}
+	return inst, nil
+}
+
+// Copy pushes the host file over adb to /mnt/apk/assets/ on the Avf_Device
+// and returns that path. The guest has already been started by Create, and
+// the payload APK itself is not modified.
+func (inst *instance) Copy(hostSrc string) (string, error) {
+	guestPath := "/mnt/apk/assets/" + filepath.Base(hostSrc)
+	cmd := exec.Command("adb", "-s", inst.cfg.AvfDevice, "push", hostSrc, guestPath)
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("failed to push %v into microdroid payload: %v", hostSrc, err)
+	}
+	return guestPath, nil
+}
+
+// Run executes command inside the guest through the vm tool's console
+// subcommand, invoked with adb shell on the Avf_Device.
+func (inst *instance) Run(timeout time.Duration, stop <-chan bool, command string) (
+	<-chan []byte, <-chan error, error) {
+	guestCmd := strings.Join(append([]string{command}, inst.cfg.ExecutorArgs()...), " ")
+	cmd := exec.Command("adb", "-s", inst.cfg.AvfDevice, "shell",
+		"/apex/com.android.virt/bin/vm", "console", "--cid", "microdroid", "--", guestCmd)
+	outc := make(chan []byte, 128)
+	cmd.Stdout = &chanWriter{outc}
+	cmd.Stderr = &chanWriter{outc}
+	if err := cmd.Start(); err != nil {
+		return nil, nil, fmt.Errorf("failed to run command in microdroid guest: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	errc := make(chan error, 1)
+	go func() {
+		defer close(outc)
+		select {
+		case err := <-done:
+			errc <- err
+		case <-stop:
+			cmd.Process.Kill()
+			<-done
+			errc <- fmt.Errorf("microdroid guest stopped")
+		case <-time.After(timeout):
+			cmd.Process.Kill()
+			<-done
+			errc <- fmt.Errorf("microdroid guest timed out")
+		}
+	}()
+	return outc, errc, nil
+}
+
+// chanWriter streams written bytes onto outc, dropping them if the consumer
+// isn't keeping up rather than blocking the guest process.
+type chanWriter struct {
+	outc chan []byte
+}
+
+func (w *chanWriter) Write(p []byte) (int, error) {
+	data := make([]byte, len(p))
+	copy(data, p)
+	select {
+	case w.outc <- data:
+	default:
+	}
+	return len(p), nil
+}
+
+func (inst *instance) Close() {
+	exec.Command("adb", "-s", inst.cfg.AvfDevice, "shell", microdroidBin, "stop-all").Run()
+}
@@ -0,0 +1,139 @@
+// Copyright 2015 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+// Package crosvm implements the vm.Pool/vm.Instance interfaces for crosvm,
+// the Chrome OS/Fuchsia native hypervisor.
+package crosvm
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"time"
+
+	"github.com/google/syzkaller/vm"
+)
+
+func init() {
+	vm.Register("crosvm", ctor)
+}
+
+// usbDeviceRe matches a Host_Devices entry given as "vendor:product", as
+// opposed to a filesystem path to a PCI/char device.
+var usbDeviceRe = regexp.MustCompile(`^[0-9a-fA-F]{4}:[0-9a-fA-F]{4}$`)
+
+type pool struct {
+	cfg *vm.Config
+}
+
+func ctor(cfg *vm.Config) (vm.Pool, error) {
+	return &pool{cfg: cfg}, nil
+}
+
+func (p *pool) Count() int {
+	return 1
+}
+
+type instance struct {
+	cfg *vm.Config
+	cmd *exec.Cmd
+}
+
+func (p *pool) Create(index int) (vm.Instance, error) {
+	return &instance{cfg: p.cfg}, nil
+}
+
+// buildArgs translates a vm.Config into the crosvm command line.
+func buildArgs(cfg *vm.Config) []string {
+	args := []string{"run"}
+	if cfg.CrosvmWaylandSocket != "" {
+		args = append(args, "--wayland-sock", cfg.CrosvmWaylandSocket)
+	}
+	for _, disk := range cfg.CrosvmRwDisks {
+		args = append(args, "--rwdisk", disk)
+	}
+	if cfg.CrosvmTap != "" {
+		args = append(args, "--tap-name", cfg.CrosvmTap)
+	}
+	if cfg.CrosvmBridge != "" {
+		args = append(args, "--net-bridge", cfg.CrosvmBridge)
+	}
+	for _, dev := range cfg.HostDevices {
+		if usbDeviceRe.MatchString(dev) {
+			// USB devices go through crosvm's usbredir mechanism, not vfio.
+			args = append(args, "--usb", dev)
+		} else {
+			args = append(args, "--vfio", dev)
+		}
+	}
+	args = append(args, cfg.CrosvmParams...)
+	args = append(args, "--kernel", cfg.Kernel)
+	if cfg.Cmdline != "" {
+		args = append(args, "-p", cfg.Cmdline)
+	}
+	args = append(args, cfg.Image)
+	return args
+}
+
+func (inst *instance) Copy(hostSrc string) (string, error) {
+	return hostSrc, nil
+}
+
+func (inst *instance) Run(timeout time.Duration, stop <-chan bool, command string) (
+	<-chan []byte, <-chan error, error) {
+	args := buildArgs(inst.cfg)
+	guestCmd := append([]string{command}, inst.cfg.ExecutorArgs()...)
+	args = append(args, "--")
+	args = append(args, guestCmd...)
+	cmd := exec.Command(inst.cfg.CrosvmBin, args...)
+	inst.cmd = cmd
+	outc := make(chan []byte, 128)
+	cmd.Stdout = &chanWriter{outc}
+	cmd.Stderr = &chanWriter{outc}
+	if err := cmd.Start(); err != nil {
+		return nil, nil, fmt.Errorf("failed to start crosvm: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	errc := make(chan error, 1)
+	go func() {
+		defer close(outc)
+		select {
+		case err := <-done:
+			errc <- err
+		case <-stop:
+			cmd.Process.Kill()
+			<-done
+			errc <- fmt.Errorf("crosvm stopped")
+		case <-time.After(timeout):
+			cmd.Process.Kill()
+			<-done
+			errc <- fmt.Errorf("crosvm timed out")
+		}
+	}()
+	return outc, errc, nil
+}
+
+// chanWriter streams written bytes onto outc, dropping them if the consumer
+// isn't keeping up rather than blocking the VM process.
+type chanWriter struct {
+	outc chan []byte
+}
+
+func (w *chanWriter) Write(p []byte) (int, error) {
+	data := make([]byte, len(p))
+	copy(data, p)
+	select {
+	case w.outc <- data:
+	default:
+	}
+	return len(p), nil
+}
+
+func (inst *instance) Close() {
+	if inst.cmd != nil && inst.cmd.Process != nil {
+		inst.cmd.Process.Kill()
+	}
+}
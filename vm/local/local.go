@@ -0,0 +1,160 @@
+// Copyright 2015 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+// Package local implements the vm.Pool/vm.Instance interfaces for type
+// "local"/"none": fuzzer processes are spawned directly on the host.
+package local
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/syzkaller/vm"
+)
+
+func init() {
+	vm.Register("local", ctor)
+	vm.Register("none", ctor)
+}
+
+const cgroupRoot = "/sys/fs/cgroup"
+
+type pool struct {
+	cfg *vm.Config
+}
+
+func ctor(cfg *vm.Config) (vm.Pool, error) {
+	return &pool{cfg: cfg}, nil
+}
+
+func (p *pool) Count() int {
+	return 1
+}
+
+type instance struct {
+	cfg    *vm.Config
+	cgroup string
+	cmd    *exec.Cmd
+}
+
+func (p *pool) Create(index int) (vm.Instance, error) {
+	cgroup, err := setupCgroup(p.cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &instance{cfg: p.cfg, cgroup: cgroup}, nil
+}
+
+// setupCgroup creates Cgroup_Parent/syz-<name>-<index>/ under /sys/fs/cgroup,
+// writes the configured limits into it and returns its path. If Cgroup_Parent
+// is not set, it returns "". If cgroup v2 isn't mounted, a warning is printed
+// and it returns "" instead of failing the instance.
+func setupCgroup(cfg *vm.Config) (string, error) {
+	if cfg.CgroupParent == "" {
+		return "", nil
+	}
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		fmt.Printf("warning: cgroup v2 is not mounted at %v, ignoring cgroup_* limits: %v\n", cgroupRoot, err)
+		return "", nil
+	}
+	path := filepath.Join(cgroupRoot, cfg.CgroupParent, fmt.Sprintf("syz-%v-%v", cfg.Name, cfg.Index))
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return "", fmt.Errorf("failed to create cgroup %v: %v", path, err)
+	}
+	writes := map[string]string{
+		"memory.max": cfg.CgroupMemMax,
+		"cpu.max":    cfg.CgroupCpuMax,
+	}
+	if cfg.CgroupPidsMax != 0 {
+		writes["pids.max"] = strconv.Itoa(cfg.CgroupPidsMax)
+	}
+	if cfg.CgroupIOWeight != 0 {
+		writes["io.weight"] = strconv.Itoa(cfg.CgroupIOWeight)
+	}
+	for file, val := range writes {
+		if val == "" {
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(path, file), []byte(val), 0644); err != nil {
+			return "", fmt.Errorf("failed to write %v: %v", file, err)
+		}
+	}
+	return path, nil
+}
+
+// addToCgroup moves pid into the instance's cgroup, if one was set up.
+func addToCgroup(cgroup string, pid int) error {
+	if cgroup == "" {
+		return nil
+	}
+	return os.WriteFile(filepath.Join(cgroup, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644)
+}
+
+func (inst *instance) Copy(hostSrc string) (string, error) {
+	return hostSrc, nil
+}
+
+func (inst *instance) Run(timeout time.Duration, stop <-chan bool, command string) (
+	<-chan []byte, <-chan error, error) {
+	fullCommand := strings.Join(append([]string{command}, inst.cfg.ExecutorArgs()...), " ")
+	cmd := exec.Command("/bin/sh", "-c", fullCommand)
+	inst.cmd = cmd
+	outc := make(chan []byte, 128)
+	cmd.Stdout = &chanWriter{outc}
+	cmd.Stderr = &chanWriter{outc}
+	if err := cmd.Start(); err != nil {
+		return nil, nil, fmt.Errorf("failed to start command: %v", err)
+	}
+	if err := addToCgroup(inst.cgroup, cmd.Process.Pid); err != nil {
+		cmd.Process.Kill()
+		return nil, nil, fmt.Errorf("failed to move pid into cgroup: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	errc := make(chan error, 1)
+	go func() {
+		defer close(outc)
+		select {
+		case err := <-done:
+			errc <- err
+		case <-stop:
+			cmd.Process.Kill()
+			<-done
+			errc <- fmt.Errorf("process stopped")
+		case <-time.After(timeout):
+			cmd.Process.Kill()
+			<-done
+			errc <- fmt.Errorf("process timed out")
+		}
+	}()
+	return outc, errc, nil
+}
+
+// chanWriter streams written bytes onto outc, dropping them if the consumer
+// isn't keeping up rather than blocking the fuzzer process.
+type chanWriter struct {
+	outc chan []byte
+}
+
+func (w *chanWriter) Write(p []byte) (int, error) {
+	data := make([]byte, len(p))
+	copy(data, p)
+	select {
+	case w.outc <- data:
+	default:
+	}
+	return len(p), nil
+}
+
+func (inst *instance) Close() {
+	if inst.cmd != nil && inst.cmd.Process != nil {
+		inst.cmd.Process.Kill()
+	}
+}
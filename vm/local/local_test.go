@@ -0,0 +1,49 @@
+// Copyright 2015 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package local
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/syzkaller/vm"
+)
+
+func TestSetupCgroupWritesLimits(t *testing.T) {
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		t.Skipf("cgroup v2 is not mounted at %v: %v", cgroupRoot, err)
+	}
+	parent, err := os.MkdirTemp(cgroupRoot, "syz-test-")
+	if err != nil {
+		t.Skipf("can't create a cgroup under %v (need root?): %v", cgroupRoot, err)
+	}
+	defer os.Remove(parent)
+	cfg := &vm.Config{
+		Name:          "smoke",
+		Index:         0,
+		CgroupParent:  filepath.Base(parent),
+		CgroupMemMax:  "134217728",
+		CgroupPidsMax: 42,
+	}
+	path, err := setupCgroup(cfg)
+	if err != nil {
+		t.Fatalf("setupCgroup failed: %v", err)
+	}
+	defer os.Remove(path)
+	for file, want := range map[string]string{
+		"memory.max": "134217728",
+		"pids.max":   "42",
+	} {
+		data, err := os.ReadFile(filepath.Join(path, file))
+		if err != nil {
+			t.Fatalf("failed to read %v: %v", file, err)
+		}
+		got := strings.TrimSpace(string(data))
+		if got != want {
+			t.Errorf("%v = %q, want %q", file, got, want)
+		}
+	}
+}
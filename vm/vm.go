@@ -0,0 +1,129 @@
+// Copyright 2015 syzkaller project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+// Package vm provides an abstract test machine (VM, physical device, etc)
+// interface for the rest of syzkaller. Concrete backends live in subpackages
+// (vm/crosvm, vm/avf, vm/local, ...) and register themselves with Register.
+package vm
+
+import (
+	"fmt"
+	"time"
+)
+
+// Config describes the parameters of one instance, as produced by
+// syz-manager/config.CreateVMConfig from the user-facing Config.
+type Config struct {
+	Name    string // unique name of the instance, used for logging and as instance prefix
+	Index   int    // index of this instance among the Count instances of the user-facing Config
+	Workdir string
+	Bin     string // VM binary name (qemu/lkvm); crosvm uses CrosvmBin instead
+	BinArgs string // additional command line arguments for Bin
+	Kernel  string
+	Cmdline string
+	Image   string
+	Initrd  string
+	Sshkey  string
+
+	Executor string // path to syz-executor binary on the host
+
+	Cpu   int
+	Mem   int
+	Debug bool
+
+	// gce-specific.
+	MachineType string
+
+	// odroid-specific.
+	OdroidHostAddr  string
+	OdroidSlaveAddr string
+	OdroidConsole   string
+	OdroidHubBus    int
+	OdroidHubDevice int
+	OdroidHubPort   int
+
+	// adb-specific.
+	Device string // adb device serial
+
+	// crosvm-specific.
+	CrosvmBin           string   // path to the crosvm binary
+	CrosvmWaylandSocket string   // wayland socket forwarded into the guest, if any
+	CrosvmRwDisks       []string // extra disks attached read-write
+	CrosvmParams        []string // additional crosvm command line arguments
+	CrosvmTap           string   // tap device to attach to the guest nic
+	CrosvmBridge        string   // bridge the tap device is attached to
+
+	// avf-specific.
+	AvfDevice      string   // host adb serial that hosts the virtualizationservice
+	AvfProtected   bool     // boot the guest as a protected (pKVM) VM
+	AvfCpuTopology string   // "one_cpu" or "match_host"
+	AvfMemoryMib   int      // guest memory size in MiB
+	AvfPayloadApk  string   // APK on the host containing the microdroid payload
+	AvfDisks       []string // extra composite disk images to attach to the guest
+
+	// HostDevices lists host devices to pass through into the guest, e.g.
+	// "/dev/kvm" or "vendor:product" for USB. Entries have already had the
+	// %INDEX% token resolved for this instance's index.
+	HostDevices []string
+
+	// local/none cgroup v2 limits, see syz-manager/config.Config.Cgroup_*.
+	CgroupParent   string // parent cgroup under /sys/fs/cgroup to nest this instance's cgroup in
+	CgroupMemMax   string // memory.max value, e.g. "2G"
+	CgroupCpuMax   string // cpu.max value, e.g. "200000 100000"
+	CgroupPidsMax  int    // pids.max value
+	CgroupIOWeight int    // io.weight value
+
+	// SandboxCapsKeep/SandboxCapsDrop are capset(2) bounding-set bitmasks for
+	// sandbox "capabilities", resolved by syz-manager/config.parseCapabilities.
+	// Backends pass them to syz-executor via ExecutorArgs.
+	SandboxCapsKeep uint64
+	SandboxCapsDrop uint64
+}
+
+// ExecutorArgs returns the extra command line flags a backend should pass to
+// syz-executor (in addition to cfg.Executor itself) to apply this config.
+func (cfg *Config) ExecutorArgs() []string {
+	var args []string
+	if cfg.SandboxCapsKeep != 0 {
+		args = append(args, fmt.Sprintf("-sandbox_caps_keep=0x%x", cfg.SandboxCapsKeep))
+	}
+	if cfg.SandboxCapsDrop != 0 {
+		args = append(args, fmt.Sprintf("-sandbox_caps_drop=0x%x", cfg.SandboxCapsDrop))
+	}
+	return args
+}
+
+// Pool manages a set of Instance's of the same kind.
+type Pool interface {
+	Count() int
+	Create(index int) (Instance, error)
+}
+
+// Instance represents one running test machine.
+type Instance interface {
+	// Copy copies a hostSrc file into the instance and returns the resulting path.
+	Copy(hostSrc string) (string, error)
+	// Run runs cmd inside the instance until it finishes, the timeout fires or stop is closed.
+	Run(timeout time.Duration, stop <-chan bool, cmd string) (outc <-chan []byte, errc <-chan error, err error)
+	// Close shuts down the instance and releases its resources.
+	Close()
+}
+
+type ctor func(cfg *Config) (Pool, error)
+
+var ctors = make(map[string]ctor)
+
+// Register makes a backend available under the given VM type name.
+// It's meant to be called from backend package init() functions.
+func Register(typ string, c ctor) {
+	ctors[typ] = c
+}
+
+// Create instantiates the Pool registered for typ.
+func Create(typ string, cfg *Config) (Pool, error) {
+	c := ctors[typ]
+	if c == nil {
+		return nil, fmt.Errorf("unknown vm type %q", typ)
+	}
+	return c(cfg)
+}